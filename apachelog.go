@@ -10,24 +10,39 @@ good to go.
 
 Example:
 
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", handler)
-		loggingHandler := apachelog.NewHandler(mux, os.Stderr)
-		server := &http.Server{
-			Addr: ":8899",
-			Handler: loggingHandler,
-		}
-		server.ListenAndServe()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handler)
+	loggingHandler := apachelog.NewHandler(mux, os.Stderr)
+	server := &http.Server{
+		Addr: ":8899",
+		Handler: loggingHandler,
+	}
+	server.ListenAndServe()
+
+NewCombinedHandler and NewJSONHandler wrap a handler the same way but log in the NCSA combined format or as
+one JSON object per request, respectively. NewCustomHandler takes a LogFormatter directly, for log formats
+other than the built-in ones. All of these write their output through an io.Writer; NewSinkHandler instead
+takes a Sink, for delivering log lines somewhere more involved than a single writer, such as a rotating file
+(RotatingFileSink) or a buffered background goroutine (AsyncSink).
+
+HandlerOptions, passed optionally to any of the above constructors, configures things like trusted-proxy
+client IP resolution, skipping requests entirely, mirroring error responses to a second destination, and
+recovering panics from the wrapped handler.
 */
 package apachelog
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
 	"time"
 )
 
@@ -35,23 +50,142 @@ import (
 // in seconds at the the end of the log line.
 const apacheFormatPattern = "%s - - [%s] \"%s %s %s\" %d %d %.4f\n"
 
+// combinedFormatPattern is the NCSA "combined" log format: the common format plus the Referer and
+// User-Agent request headers.
+const combinedFormatPattern = "%s - - [%s] \"%s %s %s\" %d %d \"%s\" \"%s\" %.4f\n"
+
 var ErrHijackingNotSupported = errors.New("hijacking is not supported")
 
+// LogFormatterParams holds the data available to a LogFormatter once a request has finished.
+type LogFormatterParams struct {
+	// Request is the request being logged. Handlers further down the chain may have mutated it (for
+	// example, its URL), so fields that need to reflect the state at dispatch time are captured
+	// separately below.
+	Request *http.Request
+	// URL is a snapshot of Request.URL taken before the wrapped handler ran.
+	URL url.URL
+	// RemoteIP is the client IP to log, already resolved according to the handler's HandlerOptions.
+	RemoteIP string
+	// StartTime is when the request was received, before the wrapped handler ran.
+	StartTime  time.Time
+	TimeStamp  time.Time
+	StatusCode int
+	Size       int64
+	Duration   time.Duration
+	// Panic is the value recovered from a panic in the wrapped handler, or nil if it returned normally.
+	// Only set when HandlerOptions.RecoverPanics is true.
+	Panic interface{}
+	// Stack is the stack trace captured at the point of a recovered panic, or nil otherwise.
+	Stack []byte
+}
+
+// LogFormatter writes a single log line describing a completed request to out.
+type LogFormatter func(out io.Writer, params LogFormatterParams)
+
+// CommonLogFormatter formats a log line in the Apache Common Log Format (with the trailing response-time
+// field described in the package doc comment). This is the formatter used by NewHandler.
+func CommonLogFormatter(out io.Writer, p LogFormatterParams) {
+	fmt.Fprintf(out, apacheFormatPattern, p.RemoteIP, p.TimeStamp.Format("02/Jan/2006 15:04:05"),
+		p.Request.Method, p.URL.RequestURI(), p.Request.Proto, p.StatusCode, p.Size, p.Duration.Seconds())
+}
+
+// CombinedLogFormatter formats a log line in the NCSA Combined Log Format: the common format plus the
+// Referer and User-Agent request headers. This is the formatter used by NewCombinedHandler.
+func CombinedLogFormatter(out io.Writer, p LogFormatterParams) {
+	fmt.Fprintf(out, combinedFormatPattern, p.RemoteIP, p.TimeStamp.Format("02/Jan/2006 15:04:05"),
+		p.Request.Method, p.URL.RequestURI(), p.Request.Proto, p.StatusCode, p.Size,
+		escapeLogField(p.Request.Header.Get("Referer")), escapeLogField(p.Request.Header.Get("User-Agent")),
+		p.Duration.Seconds())
+}
+
+// jsonLogLine is the structure written out, one per line, by JSONLogFormatter.
+type jsonLogLine struct {
+	RemoteIP           string  `json:"remote_ip"`
+	Method             string  `json:"method"`
+	URI                string  `json:"uri"`
+	Protocol           string  `json:"protocol"`
+	Host               string  `json:"host"`
+	Status             int     `json:"status"`
+	ResponseBytes      int64   `json:"response_bytes"`
+	ElapsedSeconds     float64 `json:"elapsed_seconds"`
+	ElapsedNanoseconds int64   `json:"elapsed_nanoseconds"`
+	StartTime          string  `json:"start_time"`
+	UserAgent          string  `json:"user_agent"`
+	Referer            string  `json:"referer"`
+	Panic              string  `json:"panic,omitempty"`
+	Stack              string  `json:"stack,omitempty"`
+}
+
+// JSONLogFormatter formats a log line as a single JSON object. This is the formatter used by
+// NewJSONHandler.
+func JSONLogFormatter(out io.Writer, p LogFormatterParams) {
+	line := jsonLogLine{
+		RemoteIP:           p.RemoteIP,
+		Method:             p.Request.Method,
+		URI:                p.URL.RequestURI(),
+		Protocol:           p.Request.Proto,
+		Host:               p.Request.Host,
+		Status:             p.StatusCode,
+		ResponseBytes:      p.Size,
+		ElapsedSeconds:     p.Duration.Seconds(),
+		ElapsedNanoseconds: p.Duration.Nanoseconds(),
+		StartTime:          p.StartTime.Format(time.RFC3339Nano),
+		UserAgent:          p.Request.Header.Get("User-Agent"),
+		Referer:            p.Request.Header.Get("Referer"),
+	}
+	if p.Panic != nil {
+		line.Panic = fmt.Sprint(p.Panic)
+		line.Stack = string(p.Stack)
+	}
+	if err := json.NewEncoder(out).Encode(line); err != nil {
+		fmt.Fprintf(out, `{"error":"apachelog: failed to encode log line: %s"}`+"\n", err)
+	}
+}
+
+// escapeLogField prepares a request header value for inclusion in a quoted log field, escaping embedded
+// quotes and control characters and substituting "-" for an empty value.
+func escapeLogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
 // record is a wrapper around a ResponseWriter that carries other metadata needed to write a log line.
 type record struct {
 	http.ResponseWriter
-	out io.Writer // Same as the handler's out; the record needs to be able to log itself.
+	sink      Sink // Same as the handler's sink; the record needs to be able to log itself.
+	errSink   Sink
+	formatter LogFormatter
+	skip      func(LogFormatterParams) bool
+	isError   func(LogFormatterParams) bool
 
 	// Only used for intermediate calculations
 	startTime time.Time
 
 	// Fields needed to produce log line
-	ip                    string
-	endTime               time.Time
-	method, uri, protocol string
-	status                int
-	responseBytes         int64
-	elapsedTime           time.Duration
+	request       *http.Request
+	url           url.URL // snapshot of request.URL taken before the wrapped handler ran
+	remoteIP      string
+	endTime       time.Time
+	status        int
+	wroteHeader   bool
+	responseBytes int64
+	elapsedTime   time.Duration
+	panicValue    interface{}
+	stack         []byte
 }
 
 // start sets up any initial state for this record before it is used to serve a request.
@@ -66,15 +200,51 @@ func (r *record) finish() {
 	r.log()
 }
 
-// log writes the record out as a single log line to r.out.
+// log formats the record as a single log line using r.formatter and delivers it to r.sink. Formatting
+// happens into a pooled buffer so that a single, complete line is handed to the sink rather than whatever
+// number of Write calls the formatter happens to make.
 func (r *record) log() {
-	timeFormatted := r.endTime.Format("02/Jan/2006 15:04:05")
-	fmt.Fprintf(r.out, apacheFormatPattern, r.ip, timeFormatted, r.method, r.uri, r.protocol, r.status,
-		r.responseBytes, r.elapsedTime.Seconds())
+	params := LogFormatterParams{
+		Request:    r.request,
+		URL:        r.url,
+		RemoteIP:   r.remoteIP,
+		StartTime:  r.startTime,
+		TimeStamp:  r.endTime,
+		StatusCode: r.status,
+		Size:       r.responseBytes,
+		Duration:   r.elapsedTime,
+		Panic:      r.panicValue,
+		Stack:      r.stack,
+	}
+	if r.skip != nil && r.skip(params) {
+		return
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	r.formatter(buf, params)
+
+	line := make([]byte, buf.Len())
+	copy(line, buf.Bytes())
+	r.sink.WriteRecord(LogRecord{Line: line})
+
+	if r.errSink != nil {
+		isErr := params.StatusCode >= 500
+		if r.isError != nil {
+			isErr = r.isError(params)
+		}
+		if isErr {
+			r.errSink.WriteRecord(LogRecord{Line: line})
+		}
+	}
 }
 
-// Write proxies to the underlying ResponseWriter.Write method while recording response size.
+// Write proxies to the underlying ResponseWriter.Write method while recording response size. Per
+// http.ResponseWriter's contract, an unheadered Write implicitly commits a 200 response, so this also
+// marks the header as sent.
 func (r *record) Write(p []byte) (int, error) {
+	r.wroteHeader = true
 	written, err := r.ResponseWriter.Write(p)
 	r.responseBytes += int64(written)
 	return written, err
@@ -83,6 +253,7 @@ func (r *record) Write(p []byte) (int, error) {
 // WriteHeader proxies to the underlying ResponseWriter.WriteHeader method while recording response status.
 func (r *record) WriteHeader(status int) {
 	r.status = status
+	r.wroteHeader = true
 	r.ResponseWriter.WriteHeader(status)
 }
 
@@ -95,19 +266,96 @@ func (r *record) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.Hijack()
 }
 
+// HandlerOptions configures optional handler behavior beyond the defaults. The zero value reproduces the
+// package's long-standing default behavior.
+type HandlerOptions struct {
+	// TrustedProxies lists the networks that are trusted to supply accurate client-forwarding
+	// information. If the immediate peer (http.Request.RemoteAddr) is not in one of these networks,
+	// ForwardedHeaders is ignored and the peer address is logged as-is.
+	TrustedProxies []*net.IPNet
+
+	// ForwardedHeaders lists, in order of preference, which forwarding header to trust to recover the
+	// real client address when the peer is a trusted proxy: "Forwarded" for the RFC 7239 header,
+	// "X-Forwarded-For" for the de facto standard header. If empty, forwarding headers are never
+	// consulted.
+	ForwardedHeaders []string
+
+	// Skip, if non-nil, is called once a request has finished; if it returns true the request is not
+	// logged at all. Useful for suppressing health checks, static assets, or successful 2xx responses.
+	Skip func(LogFormatterParams) bool
+
+	// ErrorOut, if non-nil, additionally receives the log line for any request considered an error, in
+	// parallel with the handler's usual output. By default "error" means StatusCode >= 500; set IsError
+	// to override that.
+	ErrorOut io.Writer
+	// IsError overrides the default StatusCode >= 500 check used to decide whether a request's log line
+	// is also written to ErrorOut. Only consulted when ErrorOut is non-nil.
+	IsError func(LogFormatterParams) bool
+
+	// RecoverPanics, if true, makes the handler recover a panic from the wrapped handler so that it
+	// still produces a log line, with the Panic/Stack fields of LogFormatterParams set, instead of
+	// letting the panic escape unlogged. The logged StatusCode is 500 if no status had been sent before
+	// the panic; otherwise it is the status already committed to the client. Leave false if something further up the chain
+	// (e.g. net/http's own recovery) already handles panics.
+	RecoverPanics bool
+	// PanicHandler, if non-nil, is called with the recovered value after a panic, in place of the
+	// handler's default behavior of writing a bare 500 response. Only consulted when RecoverPanics is
+	// true.
+	PanicHandler func(http.ResponseWriter, *http.Request, interface{})
+}
+
 // handler is an http.Handler that logs each response.
 type handler struct {
 	http.Handler
-	out io.Writer
+	sink      Sink
+	errSink   Sink
+	formatter LogFormatter
+	opts      HandlerOptions
 }
 
 // NewHandler creates a new http.Handler, given some underlying http.Handler to wrap and an output stream
-// (typically os.Stderr).
-func NewHandler(h http.Handler, out io.Writer) http.Handler {
-	return &handler{
-		Handler: h,
-		out:     out,
+// (typically os.Stderr). opts is optional; at most one may be given.
+func NewHandler(h http.Handler, out io.Writer, opts ...HandlerOptions) http.Handler {
+	return NewCustomHandler(h, out, CommonLogFormatter, opts...)
+}
+
+// NewCombinedHandler creates a new http.Handler, like NewHandler, but logs in the NCSA "combined" log
+// format: the common format with the Referer and User-Agent request headers added.
+func NewCombinedHandler(h http.Handler, out io.Writer, opts ...HandlerOptions) http.Handler {
+	return NewCustomHandler(h, out, CombinedLogFormatter, opts...)
+}
+
+// NewCustomHandler creates a new http.Handler, like NewHandler, but using formatter to produce each log
+// line instead of one of the built-in formats.
+func NewCustomHandler(h http.Handler, out io.Writer, formatter LogFormatter, opts ...HandlerOptions) http.Handler {
+	return NewSinkHandler(h, NewWriterSink(out), formatter, opts...)
+}
+
+// NewJSONHandler creates a new http.Handler, like NewHandler, but logs one JSON object per request instead
+// of an Apache-style text line.
+func NewJSONHandler(h http.Handler, out io.Writer, opts ...HandlerOptions) http.Handler {
+	return NewCustomHandler(h, out, JSONLogFormatter, opts...)
+}
+
+// NewSinkHandler creates a new http.Handler, like NewHandler, but delivers each formatted log line to sink
+// instead of writing directly to an io.Writer. Use this to plug in a RotatingFileSink, an AsyncSink, or a
+// custom Sink implementation.
+func NewSinkHandler(h http.Handler, sink Sink, formatter LogFormatter, opts ...HandlerOptions) http.Handler {
+	if len(opts) > 1 {
+		panic("apachelog: at most one HandlerOptions may be given")
 	}
+	hdl := &handler{
+		Handler:   h,
+		sink:      sink,
+		formatter: formatter,
+	}
+	if len(opts) == 1 {
+		hdl.opts = opts[0]
+		if hdl.opts.ErrorOut != nil {
+			hdl.errSink = NewWriterSink(hdl.opts.ErrorOut)
+		}
+	}
+	return hdl
 }
 
 // ServeHTTP delegates to the underlying handler's ServeHTTP method and writes one log line for every call.
@@ -115,13 +363,37 @@ func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	rec := new(record)
 	rec.start()
 	rec.ResponseWriter = rw
-	rec.out = h.out
-	rec.ip = getIP(r.RemoteAddr)
-	rec.method = r.Method
-	rec.uri = r.RequestURI
-	rec.protocol = r.Proto
+	rec.sink = h.sink
+	rec.errSink = h.errSink
+	rec.formatter = h.formatter
+	rec.skip = h.opts.Skip
+	rec.isError = h.opts.IsError
+	rec.request = r
+	// Snapshot the URL before dispatching: downstream handlers may mutate r.URL, and the log line
+	// should reflect the request as it came in.
+	if r.URL != nil {
+		rec.url = *r.URL
+	}
+	rec.remoteIP = clientIP(r, h.opts)
 	rec.status = http.StatusOK
 
+	if h.opts.RecoverPanics {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			rec.panicValue = p
+			rec.stack = debug.Stack()
+			if h.opts.PanicHandler != nil {
+				h.opts.PanicHandler(rec, r, p)
+			} else if !rec.wroteHeader {
+				rec.WriteHeader(http.StatusInternalServerError)
+			}
+			rec.finish()
+		}()
+	}
+
 	h.Handler.ServeHTTP(rec, r)
 	rec.finish()
 }
@@ -0,0 +1,189 @@
+package apachelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type captureSink struct {
+	records []LogRecord
+}
+
+func (s *captureSink) WriteRecord(rec LogRecord) error {
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func TestPanicRecoveryLogsCommittedStatus(t *testing.T) {
+	cases := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+	}{
+		{
+			name: "panic before any output logs a fabricated 500",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "panic after a partial write logs the status already committed to the client",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("partial"))
+				panic("boom")
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "panic after an explicit WriteHeader logs that status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("boom")
+			},
+			wantStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sink := &captureSink{}
+			h := NewSinkHandler(c.handler, sink, JSONLogFormatter, HandlerOptions{RecoverPanics: true})
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						t.Fatalf("panic escaped the handler: %v", p)
+					}
+				}()
+				h.ServeHTTP(rw, req)
+			}()
+
+			if len(sink.records) != 1 {
+				t.Fatalf("got %d log records, want 1", len(sink.records))
+			}
+			var line jsonLogLine
+			if err := json.Unmarshal(sink.records[0].Line, &line); err != nil {
+				t.Fatalf("unmarshal log line: %s", err)
+			}
+			if line.Status != c.wantStatus {
+				t.Errorf("logged status = %d, want %d", line.Status, c.wantStatus)
+			}
+			if line.Panic == "" {
+				t.Errorf("expected the log line's panic field to be set")
+			}
+		})
+	}
+}
+
+func TestNewCustomHandlerUsesFormatterAndSnapshotsURL(t *testing.T) {
+	var out bytes.Buffer
+	var sawURL string
+	formatter := func(w io.Writer, p LogFormatterParams) {
+		sawURL = p.URL.String()
+		io.WriteString(w, "custom "+p.Request.Method+" "+p.URL.Path+"\n")
+	}
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = "/mutated" // simulates a downstream handler rewriting the request's URL
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := NewCustomHandler(inner, &out, formatter)
+	req := httptest.NewRequest(http.MethodGet, "/original", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if sawURL != "/original" {
+		t.Errorf("formatter saw URL %q, want %q (the pre-dispatch snapshot)", sawURL, "/original")
+	}
+	if got, want := out.String(), "custom GET /original\n"; got != want {
+		t.Errorf("logged line = %q, want %q", got, want)
+	}
+}
+
+func TestSkipSuppressesLogging(t *testing.T) {
+	sink := &captureSink{}
+	skip := func(p LogFormatterParams) bool { return p.URL.Path == "/healthz" }
+	h := NewSinkHandler(http.NotFoundHandler(), sink, CommonLogFormatter, HandlerOptions{Skip: skip})
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if len(sink.records) != 0 {
+		t.Fatalf("got %d log records for a skipped request, want 0", len(sink.records))
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/other", nil))
+	if len(sink.records) != 1 {
+		t.Fatalf("got %d log records for a non-skipped request, want 1", len(sink.records))
+	}
+}
+
+func TestErrorOutMirrorsDefaultAndCustomErrorPredicate(t *testing.T) {
+	respondWith := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) }
+	}
+
+	t.Run("default predicate mirrors 5xx only", func(t *testing.T) {
+		sink := &captureSink{}
+		var errOut bytes.Buffer
+		h := NewSinkHandler(respondWith(http.StatusInternalServerError), sink, CommonLogFormatter,
+			HandlerOptions{ErrorOut: &errOut})
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if len(sink.records) != 1 || errOut.Len() == 0 {
+			t.Fatalf("got %d sink records and errOut %q for a 500, want 1 record and a mirrored line",
+				len(sink.records), errOut.String())
+		}
+
+		sink, errOut = &captureSink{}, bytes.Buffer{}
+		h = NewSinkHandler(respondWith(http.StatusOK), sink, CommonLogFormatter,
+			HandlerOptions{ErrorOut: &errOut})
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if len(sink.records) != 1 || errOut.Len() != 0 {
+			t.Fatalf("got %d sink records and errOut %q for a 200, want 1 record and nothing mirrored",
+				len(sink.records), errOut.String())
+		}
+	})
+
+	t.Run("custom predicate overrides the default", func(t *testing.T) {
+		isError := func(p LogFormatterParams) bool { return p.StatusCode == http.StatusNotFound }
+
+		sink := &captureSink{}
+		var errOut bytes.Buffer
+		h := NewSinkHandler(respondWith(http.StatusNotFound), sink, CommonLogFormatter,
+			HandlerOptions{ErrorOut: &errOut, IsError: isError})
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if errOut.Len() == 0 {
+			t.Fatalf("got nothing mirrored to errOut for a 404 under a custom predicate, want a mirrored line")
+		}
+
+		sink, errOut = &captureSink{}, bytes.Buffer{}
+		h = NewSinkHandler(respondWith(http.StatusInternalServerError), sink, CommonLogFormatter,
+			HandlerOptions{ErrorOut: &errOut, IsError: isError})
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if errOut.Len() != 0 {
+			t.Fatalf("got %q mirrored to errOut for a 500 excluded by a custom predicate, want nothing", errOut.String())
+		}
+	})
+}
+
+func TestEscapeLogField(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"", "-"},
+		{`hello "world"`, `hello \"world\"`},
+		{"back\\slash", `back\\slash`},
+		{"tab\there", `tab\x09here`},
+	}
+	for _, c := range cases {
+		if got := escapeLogField(c.in); got != c.want {
+			t.Errorf("escapeLogField(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package apachelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testParams builds a representative LogFormatterParams for exercising the built-in formatters.
+func testParams(t *testing.T) LogFormatterParams {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/foo?bar=baz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "203.0.113.5:1234"
+	req.Header.Set("Referer", "http://example.com/referrer")
+	req.Header.Set("User-Agent", "test-agent/1.0")
+
+	ts := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	return LogFormatterParams{
+		Request:    req,
+		URL:        *req.URL,
+		RemoteIP:   "203.0.113.5",
+		StartTime:  ts,
+		TimeStamp:  ts,
+		StatusCode: http.StatusOK,
+		Size:       42,
+		Duration:   123 * time.Millisecond,
+	}
+}
+
+func TestCombinedLogFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	CombinedLogFormatter(&buf, testParams(t))
+
+	want := "203.0.113.5 - - [02/Jan/2024 03:04:05] \"GET /foo?bar=baz HTTP/1.1\" 200 42 " +
+		"\"http://example.com/referrer\" \"test-agent/1.0\" 0.1230\n"
+	if got := buf.String(); got != want {
+		t.Errorf("CombinedLogFormatter output =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCommonLogFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	CommonLogFormatter(&buf, testParams(t))
+
+	want := "203.0.113.5 - - [02/Jan/2024 03:04:05] \"GET /foo?bar=baz HTTP/1.1\" 200 42 0.1230\n"
+	if got := buf.String(); got != want {
+		t.Errorf("CommonLogFormatter output =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestJSONLogFormatter(t *testing.T) {
+	p := testParams(t)
+	p.Request.Host = "example.com"
+
+	var buf bytes.Buffer
+	JSONLogFormatter(&buf, p)
+
+	var line jsonLogLine
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("unmarshal log line: %s", err)
+	}
+
+	want := jsonLogLine{
+		RemoteIP:           "203.0.113.5",
+		Method:             "GET",
+		URI:                "/foo?bar=baz",
+		Protocol:           "HTTP/1.1",
+		Host:               "example.com",
+		Status:             http.StatusOK,
+		ResponseBytes:      42,
+		ElapsedSeconds:     0.123,
+		ElapsedNanoseconds: (123 * time.Millisecond).Nanoseconds(),
+		StartTime:          p.StartTime.Format(time.RFC3339Nano),
+		UserAgent:          "test-agent/1.0",
+		Referer:            "http://example.com/referrer",
+	}
+	if line != want {
+		t.Errorf("JSONLogFormatter line =\n%+v\nwant\n%+v", line, want)
+	}
+}
+
+func TestCombinedLogFormatterMissingHeaders(t *testing.T) {
+	p := testParams(t)
+	p.Request.Header.Del("Referer")
+	p.Request.Header.Del("User-Agent")
+
+	var buf bytes.Buffer
+	CombinedLogFormatter(&buf, p)
+
+	want := "203.0.113.5 - - [02/Jan/2024 03:04:05] \"GET /foo?bar=baz HTTP/1.1\" 200 42 \"-\" \"-\" 0.1230\n"
+	if got := buf.String(); got != want {
+		t.Errorf("CombinedLogFormatter output =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package apachelog
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP determines the IP address to log for r. By default (or if the immediate peer isn't a trusted
+// proxy) this is just the peer address from r.RemoteAddr. If the peer is listed in opts.TrustedProxies,
+// opts.ForwardedHeaders are consulted in order to recover the original client address from a forwarding
+// header.
+func clientIP(r *http.Request, opts HandlerOptions) string {
+	peerHost := getIP(r.RemoteAddr)
+	if len(opts.TrustedProxies) == 0 || len(opts.ForwardedHeaders) == 0 {
+		return peerHost
+	}
+	peerIP := net.ParseIP(peerHost)
+	if peerIP == nil || !ipInNets(peerIP, opts.TrustedProxies) {
+		return peerHost
+	}
+	for _, header := range opts.ForwardedHeaders {
+		var ip string
+		var ok bool
+		switch {
+		case strings.EqualFold(header, "Forwarded"):
+			ip, ok = parseForwarded(r.Header.Get("Forwarded"), opts.TrustedProxies)
+		case strings.EqualFold(header, "X-Forwarded-For"):
+			ip, ok = parseXFF(r.Header.Get("X-Forwarded-For"), opts.TrustedProxies)
+		}
+		if ok {
+			return ip
+		}
+	}
+	return peerHost
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseXFF walks an X-Forwarded-For header's comma-separated address list from right (nearest hop) to
+// left, skipping addresses that are themselves trusted proxies, and returns the first one that isn't.
+func parseXFF(header string, proxies []*net.IPNet) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+	parts := strings.Split(header, ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		host := stripForwardedHost(strings.TrimSpace(parts[i]))
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if ipInNets(ip, proxies) {
+			continue
+		}
+		return host, true
+	}
+	return stripForwardedHost(strings.TrimSpace(parts[0])), true
+}
+
+// parseForwarded extracts the "for" parameter from each hop of an RFC 7239 Forwarded header and, like
+// parseXFF, walks them from right to left skipping trusted proxies.
+func parseForwarded(header string, proxies []*net.IPNet) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+	var fors []string
+	for _, hop := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(hop, ";") {
+			name, value, found := splitForwardedPair(pair)
+			if !found || !strings.EqualFold(name, "for") {
+				continue
+			}
+			fors = append(fors, strings.Trim(value, `"`))
+		}
+	}
+	if len(fors) == 0 {
+		return "", false
+	}
+	for i := len(fors) - 1; i >= 0; i-- {
+		host := stripForwardedHost(fors[i])
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if ipInNets(ip, proxies) {
+			continue
+		}
+		return host, true
+	}
+	return stripForwardedHost(fors[0]), true
+}
+
+func splitForwardedPair(pair string) (name, value string, ok bool) {
+	pair = strings.TrimSpace(pair)
+	i := strings.IndexByte(pair, '=')
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:]), true
+}
+
+// stripForwardedHost extracts the host from a forwarded-for address, handling a bracketed IPv6 literal
+// with an optional port (e.g. "[::1]:1234" or "[::1]") as well as a bare IPv4 address with an optional
+// port.
+func stripForwardedHost(s string) string {
+	if strings.HasPrefix(s, "[") {
+		if end := strings.IndexByte(s, ']'); end >= 0 {
+			return s[1:end]
+		}
+		return s
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	return s
+}
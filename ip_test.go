@@ -0,0 +1,178 @@
+package apachelog
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %s", s, err)
+	}
+	return n
+}
+
+func TestStripForwardedHost(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"203.0.113.5", "203.0.113.5"},
+		{"203.0.113.5:1234", "203.0.113.5"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:1234", "2001:db8::1"},
+		{"[::1]", "::1"},
+	}
+	for _, c := range cases {
+		if got := stripForwardedHost(c.in); got != c.want {
+			t.Errorf("stripForwardedHost(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseXFF(t *testing.T) {
+	proxies := []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}
+
+	cases := []struct {
+		name   string
+		header string
+		wantIP string
+		wantOK bool
+	}{
+		{"empty header", "", "", false},
+		{
+			"client, trusted proxy",
+			"203.0.113.5, 10.0.0.1",
+			"203.0.113.5",
+			true,
+		},
+		{
+			"client, trusted proxy, trusted proxy",
+			"203.0.113.5, 10.0.0.1, 10.0.0.2",
+			"203.0.113.5",
+			true,
+		},
+		{
+			"untrusted hop closest to us is taken as-is",
+			"203.0.113.5, 198.51.100.7",
+			"198.51.100.7",
+			true,
+		},
+		{
+			"all hops trusted falls back to the leftmost",
+			"10.0.0.3, 10.0.0.2, 10.0.0.1",
+			"10.0.0.3",
+			true,
+		},
+		{
+			"malformed entries are skipped",
+			"not-an-ip, 203.0.113.5, 10.0.0.1",
+			"203.0.113.5",
+			true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gotIP, gotOK := parseXFF(c.header, proxies)
+			if gotOK != c.wantOK || gotIP != c.wantIP {
+				t.Errorf("parseXFF(%q) = (%q, %v), want (%q, %v)", c.header, gotIP, gotOK, c.wantIP, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	proxies := []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}
+
+	cases := []struct {
+		name   string
+		header string
+		wantIP string
+		wantOK bool
+	}{
+		{"empty header", "", "", false},
+		{
+			"simple for",
+			`for=203.0.113.5`,
+			"203.0.113.5",
+			true,
+		},
+		{
+			"quoted bracketed IPv6 with port",
+			`for="[2001:db8:cafe::17]:4711"`,
+			"2001:db8:cafe::17",
+			true,
+		},
+		{
+			"chain skips trusted proxy hop",
+			`for=203.0.113.5;proto=http, for=10.0.0.1;proto=http`,
+			"203.0.113.5",
+			true,
+		},
+		{
+			"no for parameter",
+			`proto=http;by=10.0.0.1`,
+			"",
+			false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gotIP, gotOK := parseForwarded(c.header, proxies)
+			if gotOK != c.wantOK || gotIP != c.wantIP {
+				t.Errorf("parseForwarded(%q) = (%q, %v), want (%q, %v)", c.header, gotIP, gotOK, c.wantIP, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}
+
+	newReq := func(remoteAddr, xff, forwarded string) *http.Request {
+		r := &http.Request{
+			RemoteAddr: remoteAddr,
+			Header:     make(http.Header),
+		}
+		if xff != "" {
+			r.Header.Set("X-Forwarded-For", xff)
+		}
+		if forwarded != "" {
+			r.Header.Set("Forwarded", forwarded)
+		}
+		return r
+	}
+
+	t.Run("default options ignore forwarding headers", func(t *testing.T) {
+		r := newReq("10.0.0.1:1234", "203.0.113.5", "")
+		if got := clientIP(r, HandlerOptions{}); got != "10.0.0.1" {
+			t.Errorf("clientIP = %q, want %q", got, "10.0.0.1")
+		}
+	})
+
+	t.Run("untrusted peer is logged as-is", func(t *testing.T) {
+		r := newReq("198.51.100.1:1234", "203.0.113.5", "")
+		opts := HandlerOptions{TrustedProxies: proxies, ForwardedHeaders: []string{"X-Forwarded-For"}}
+		if got := clientIP(r, opts); got != "198.51.100.1" {
+			t.Errorf("clientIP = %q, want %q", got, "198.51.100.1")
+		}
+	})
+
+	t.Run("trusted peer defers to X-Forwarded-For", func(t *testing.T) {
+		r := newReq("10.0.0.1:1234", "203.0.113.5", "")
+		opts := HandlerOptions{TrustedProxies: proxies, ForwardedHeaders: []string{"X-Forwarded-For"}}
+		if got := clientIP(r, opts); got != "203.0.113.5" {
+			t.Errorf("clientIP = %q, want %q", got, "203.0.113.5")
+		}
+	})
+
+	t.Run("trusted peer defers to Forwarded", func(t *testing.T) {
+		r := newReq("10.0.0.1:1234", "", `for="[2001:db8::1]:555"`)
+		opts := HandlerOptions{TrustedProxies: proxies, ForwardedHeaders: []string{"Forwarded"}}
+		if got := clientIP(r, opts); got != "2001:db8::1" {
+			t.Errorf("clientIP = %q, want %q", got, "2001:db8::1")
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package apachelog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrSinkClosed is returned by AsyncSink.WriteRecord once Close has been called.
+var ErrSinkClosed = errors.New("apachelog: sink is closed")
+
+// LogRecord is a single fully-formatted log line, ready to be delivered by a Sink.
+type LogRecord struct {
+	// Line is the formatted log line, including its trailing newline.
+	Line []byte
+}
+
+// Sink delivers formatted log lines somewhere: a file, a socket, a channel, etc. Implementations must be
+// safe for concurrent use, since a handler may call WriteRecord from many goroutines at once.
+type Sink interface {
+	WriteRecord(rec LogRecord) error
+}
+
+// bufPool holds *bytes.Buffer values used to format a log line before it is handed to a Sink, so that a
+// handler under load doesn't allocate a new buffer per request.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// writerSink is a Sink that writes each record to an underlying io.Writer, serialized with a mutex so that
+// lines from concurrent requests can't interleave.
+type writerSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriterSink creates a Sink that writes each log line to out with a single Write call, serialized so
+// that concurrent requests can't interleave partial lines. This is the Sink used internally by NewHandler
+// and friends.
+func NewWriterSink(out io.Writer) Sink {
+	return &writerSink{out: out}
+}
+
+func (s *writerSink) WriteRecord(rec LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.out.Write(rec.Line)
+	return err
+}
+
+// RotatingFileSink is a Sink that writes to a file, rotating it before a write that would take it past
+// MaxSize bytes or once MaxAge has elapsed since it was opened, whichever comes first. A zero MaxSize or MaxAge disables that
+// trigger. The rotated-out file is renamed to its path plus a timestamp suffix.
+type RotatingFileSink struct {
+	Path    string
+	MaxSize int64
+	MaxAge  time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewRotatingFileSink opens (creating if necessary) the file at path and returns a Sink that rotates it
+// according to maxSize and maxAge. A zero maxSize or maxAge disables that trigger.
+func NewRotatingFileSink(path string, maxSize int64, maxAge time.Duration) (*RotatingFileSink, error) {
+	s := &RotatingFileSink{
+		Path:    path,
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RotatingFileSink) open() error {
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	s.openedAt = time.Now()
+	return nil
+}
+
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", s.Path, time.Now().Format("20060102T150405.000000000"))
+	if err := os.Rename(s.Path, rotated); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// WriteRecord writes rec to the current file, rotating first if writing rec would exceed MaxSize or if
+// MaxAge has elapsed.
+func (s *RotatingFileSink) WriteRecord(rec LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	needsRotate := (s.MaxSize > 0 && s.size+int64(len(rec.Line)) > s.MaxSize) ||
+		(s.MaxAge > 0 && time.Since(s.openedAt) >= s.MaxAge)
+	if needsRotate {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(rec.Line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the underlying file.
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// OverflowPolicy controls what an AsyncSink does with a record when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes WriteRecord block until there is room in the buffer.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop makes WriteRecord drop the record instead of blocking.
+	OverflowDrop
+)
+
+// AsyncSink buffers records in a channel and delivers them to an underlying Sink from a single background
+// goroutine, so that request handling does not wait on slow log I/O while the buffer has room. Once the
+// buffer is full, WriteRecord blocks or drops the record according to its OverflowPolicy.
+type AsyncSink struct {
+	out      Sink
+	overflow OverflowPolicy
+	records  chan LogRecord
+	done     chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewAsyncSink starts a goroutine that delivers records to out and returns a Sink that buffers up to
+// bufferSize records for it. overflow determines what happens to a WriteRecord call once the buffer is
+// full.
+func NewAsyncSink(out Sink, bufferSize int, overflow OverflowPolicy) *AsyncSink {
+	s := &AsyncSink{
+		out:      out,
+		overflow: overflow,
+		records:  make(chan LogRecord, bufferSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *AsyncSink) run() {
+	defer close(s.done)
+	for rec := range s.records {
+		s.out.WriteRecord(rec)
+	}
+}
+
+// WriteRecord enqueues rec for delivery by the background goroutine. It returns ErrSinkClosed once Close
+// has been called instead of sending on the closed records channel. Delivery errors from the underlying
+// sink are not otherwise surfaced to the caller.
+//
+// s.mu is held for the duration of a blocking (OverflowBlock) send so that Close can't close the records
+// channel out from under a send already in progress; Close waits for the same lock, so a WriteRecord that
+// starts before Close is called is always allowed to finish.
+func (s *AsyncSink) WriteRecord(rec LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSinkClosed
+	}
+	if s.overflow == OverflowDrop {
+		select {
+		case s.records <- rec:
+		default:
+		}
+		return nil
+	}
+	s.records <- rec
+	return nil
+}
+
+// Close stops accepting new records, returning ErrSinkClosed from any later WriteRecord call, and blocks
+// until all records already buffered have been delivered.
+func (s *AsyncSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}